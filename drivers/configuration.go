@@ -0,0 +1,253 @@
+package drivers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/pkg/parsers/kernel"
+	pluginNet "github.com/docker/go-plugins-helpers/network"
+	"github.com/docker/libnetwork/netlabel"
+)
+
+const (
+	// ipvlanKernelMajor.ipvlanKernelMinor is the first kernel release that
+	// carries the ipvlan netlink link type.
+	ipvlanKernelMajor = 4
+	ipvlanKernelMinor = 2
+	// l3s mode and the ipvlan_flag option both depend on driver features
+	// that only landed in 4.9.
+	ipvlanL3KernelMajor = 4
+	ipvlanL3KernelMinor = 9
+
+	driverOpt   = "driver"   // -o driver=ipvlan, selects the network type per-network
+	internalOpt = "internal" // -o internal=true, use a dummy parent instead of a physical NIC
+
+	// ipvlan_flag values; private/vepa reuse the macvlan mode literals since
+	// the kernel options are named identically.
+	flagBubble = "bubble"
+
+	minVlanID = 1
+	maxVlanID = 4094
+)
+
+// configuration is bound from the libnetwork generic options passed to
+// AllocateNetwork and drives how the parent link and slave devices are
+// created for a given network.
+type configuration struct {
+	ID          string
+	Parent      string
+	NetworkType string // macvlanType or ipvlanType
+	MacvlanMode string
+	IpvlanMode  string
+	IpvlanFlag  string
+	Ifaces      []*ipSubnet
+
+	// ParentIface and VlanID are set when Parent is given in the
+	// "<iface>.<vlan_id>" convention, e.g. parent=eth0.40.
+	ParentIface string
+	VlanID      int
+	// CreatedVlanLink records that this driver, not the user, created the
+	// 802.1q sub-interface named by Parent, so only the driver may remove it.
+	CreatedVlanLink bool
+
+	// Internal networks have no physical parent NIC; the driver creates a
+	// host dummy link (see getDummyName) and uses it as the macvlan/ipvlan
+	// parent instead.
+	Internal         bool
+	CreatedDummyLink bool
+
+	dbIndex  uint64
+	dbExists bool
+}
+
+// ipSubnet is a single IPv4 or IPv6 pool/gateway pair bound to a network, as
+// handed to us via IPAMData.
+type ipSubnet struct {
+	SubnetIP string
+	GwIP     string
+}
+
+// parseNetworkOptions parses the generic options passed to AllocateNetwork
+// into a configuration, applying defaults for anything left unspecified.
+func parseNetworkOptions(id string, option map[string]interface{}) (*configuration, error) {
+	config := &configuration{NetworkType: macvlanType}
+
+	genericData, ok := option[netlabel.GenericData]
+	if !ok || genericData == nil {
+		// no options means no parent, which implies an internal network
+		config.Internal = true
+		return config, nil
+	}
+
+	opts, ok := genericData.(map[string]string)
+	if !ok {
+		return nil, fmt.Errorf("invalid generic options for %s network %s", macvlanType, id)
+	}
+
+	if driver, ok := opts[driverOpt]; ok && driver != "" {
+		config.NetworkType = driver
+	}
+	config.Parent = opts[parentOpt]
+	config.MacvlanMode = opts[macvlanType+modeOpt]
+	config.IpvlanMode = opts[ipvlanType+modeOpt]
+	config.IpvlanFlag = opts[ipvlanType+"_flag"]
+
+	if v, ok := opts[internalOpt]; ok {
+		internal, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s option", v, internalOpt)
+		}
+		config.Internal = internal
+	}
+	// an empty parent is shorthand for an internal, host-only network
+	if config.Parent == "" {
+		config.Internal = true
+	}
+
+	return config, nil
+}
+
+// checkKernelCompatibility verifies the running kernel supports the
+// requested network type/mode/flag, returning a descriptive error if not.
+func (config *configuration) checkKernelCompatibility() error {
+	if config.NetworkType != ipvlanType {
+		return nil
+	}
+
+	kv, err := kernel.GetKernelVersion()
+	if err != nil {
+		return fmt.Errorf("unable to determine kernel version for %s support: %v", ipvlanType, err)
+	}
+
+	return config.checkKernelVersion(*kv)
+}
+
+// checkKernelVersion holds the actual version-floor comparison out of
+// checkKernelCompatibility so it can be exercised without a live kernel.
+func (config *configuration) checkKernelVersion(kv kernel.VersionInfo) error {
+	if config.NetworkType != ipvlanType {
+		return nil
+	}
+
+	minVersion := kernel.VersionInfo{Kernel: ipvlanKernelMajor, Major: ipvlanKernelMinor}
+	needsL3 := config.IpvlanMode == modeL3S || config.IpvlanFlag != flagBubble
+	if needsL3 {
+		minVersion = kernel.VersionInfo{Kernel: ipvlanL3KernelMajor, Major: ipvlanL3KernelMinor}
+	}
+	if kernel.CompareKernelVersion(kv, minVersion) < 0 {
+		return fmt.Errorf("kernel %s does not support %s mode '%s' with flag '%s', requires >= %d.%d",
+			kv, ipvlanType, config.IpvlanMode, config.IpvlanFlag, minVersion.Kernel, minVersion.Major)
+	}
+
+	return nil
+}
+
+// validateVlanID checks that a parsed "<iface>.<vlan_id>" tag falls within
+// the range the kernel accepts for 802.1q sub-interfaces.
+func validateVlanID(vlanID int) error {
+	if vlanID < minVlanID || vlanID > maxVlanID {
+		return fmt.Errorf("vlan id %d is outside the valid range %d-%d", vlanID, minVlanID, maxVlanID)
+	}
+
+	return nil
+}
+
+// processIPAM validates and binds the IPAM pool/gateway data handed to
+// AllocateNetwork onto the configuration's Ifaces list.
+func (config *configuration) processIPAM(id string, ipamV4Data, ipamV6Data []*pluginNet.IPAMData) error {
+	for _, ipd := range ipamV4Data {
+		s, err := newIPSubnet(ipd)
+		if err != nil {
+			return fmt.Errorf("invalid ipv4 pool data for network %s: %v", id, err)
+		}
+		config.Ifaces = append(config.Ifaces, s)
+	}
+	for _, ipd := range ipamV6Data {
+		s, err := newIPSubnet(ipd)
+		if err != nil {
+			return fmt.Errorf("invalid ipv6 pool data for network %s: %v", id, err)
+		}
+		config.Ifaces = append(config.Ifaces, s)
+	}
+
+	return nil
+}
+
+// parseVlanParent splits a parent interface given in the "<iface>.<vlan_id>"
+// convention (e.g. "eth0.40") into its base interface and numeric tag. ok is
+// false if parent has no integer after its last ".", in which case it should
+// be treated as an ordinary, already-existing parent link. The tag's range is
+// not checked here; see validateVlanID.
+func parseVlanParent(parent string) (base string, vlanID int, ok bool) {
+	idx := strings.LastIndex(parent, ".")
+	if idx < 0 {
+		return parent, 0, false
+	}
+
+	id, err := strconv.Atoi(parent[idx+1:])
+	if err != nil {
+		return parent, 0, false
+	}
+
+	return parent[:idx], id, true
+}
+
+// Key implements KVObject, keying the record as <driver>/<network>.
+func (config *configuration) Key() []string {
+	return []string{config.NetworkType, config.ID}
+}
+
+// Value implements KVObject.
+func (config *configuration) Value() []byte {
+	b, err := json.Marshal(config)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// SetValue implements KVObject.
+func (config *configuration) SetValue(value []byte) error {
+	return json.Unmarshal(value, config)
+}
+
+// Index implements KVObject.
+func (config *configuration) Index() uint64 {
+	return config.dbIndex
+}
+
+// SetIndex implements KVObject.
+func (config *configuration) SetIndex(index uint64) {
+	config.dbIndex = index
+	config.dbExists = true
+}
+
+// Exists implements KVObject.
+func (config *configuration) Exists() bool {
+	return config.dbExists
+}
+
+// SetExists implements KVObject.
+func (config *configuration) SetExists(exists bool) {
+	config.dbExists = exists
+}
+
+func newIPSubnet(ipd *pluginNet.IPAMData) (*ipSubnet, error) {
+	if ipd.Pool == "" {
+		return nil, fmt.Errorf("empty pool")
+	}
+	if _, _, err := net.ParseCIDR(ipd.Pool); err != nil {
+		return nil, err
+	}
+	gw := ipd.Gateway
+	if gw != "" {
+		if _, _, err := net.ParseCIDR(gw); err != nil {
+			return nil, fmt.Errorf("invalid gateway %q: %v", gw, err)
+		}
+	}
+
+	return &ipSubnet{SubnetIP: ipd.Pool, GwIP: gw}, nil
+}
@@ -0,0 +1,178 @@
+package drivers
+
+import (
+	"testing"
+
+	"github.com/docker/docker/pkg/parsers/kernel"
+	pluginNet "github.com/docker/go-plugins-helpers/network"
+	"github.com/docker/libnetwork/netlabel"
+)
+
+func TestParseVlanParent(t *testing.T) {
+	cases := []struct {
+		parent     string
+		wantBase   string
+		wantVlanID int
+		wantOK     bool
+	}{
+		{"eth0.40", "eth0", 40, true},
+		{"eth0", "eth0", 0, false},
+		{"eth0.vlan", "eth0.vlan", 0, false},
+		{"bond0.100", "bond0", 100, true},
+		{"", "", 0, false},
+	}
+
+	for _, c := range cases {
+		base, vlanID, ok := parseVlanParent(c.parent)
+		if base != c.wantBase || vlanID != c.wantVlanID || ok != c.wantOK {
+			t.Errorf("parseVlanParent(%q) = (%q, %d, %v), want (%q, %d, %v)",
+				c.parent, base, vlanID, ok, c.wantBase, c.wantVlanID, c.wantOK)
+		}
+	}
+}
+
+func TestValidateVlanID(t *testing.T) {
+	cases := []struct {
+		vlanID  int
+		wantErr bool
+	}{
+		{minVlanID, false},
+		{maxVlanID, false},
+		{100, false},
+		{minVlanID - 1, true},
+		{maxVlanID + 1, true},
+		{0, true},
+	}
+
+	for _, c := range cases {
+		err := validateVlanID(c.vlanID)
+		if (err != nil) != c.wantErr {
+			t.Errorf("validateVlanID(%d) error = %v, wantErr %v", c.vlanID, err, c.wantErr)
+		}
+	}
+}
+
+func TestNewIPSubnet(t *testing.T) {
+	cases := []struct {
+		name    string
+		ipd     *pluginNet.IPAMData
+		wantErr bool
+	}{
+		{"valid pool, no gateway", &pluginNet.IPAMData{Pool: "172.18.0.0/16"}, false},
+		{"valid pool and gateway", &pluginNet.IPAMData{Pool: "172.18.0.0/16", Gateway: "172.18.0.1/16"}, false},
+		{"empty pool", &pluginNet.IPAMData{Pool: ""}, true},
+		{"malformed pool", &pluginNet.IPAMData{Pool: "not-a-cidr"}, true},
+		{"malformed gateway", &pluginNet.IPAMData{Pool: "172.18.0.0/16", Gateway: "not-a-cidr"}, true},
+	}
+
+	for _, c := range cases {
+		s, err := newIPSubnet(c.ipd)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: newIPSubnet() error = %v, wantErr %v", c.name, err, c.wantErr)
+			continue
+		}
+		if err == nil && s.SubnetIP != c.ipd.Pool {
+			t.Errorf("%s: newIPSubnet().SubnetIP = %q, want %q", c.name, s.SubnetIP, c.ipd.Pool)
+		}
+	}
+}
+
+func TestParseNetworkOptions(t *testing.T) {
+	t.Run("no generic data defaults to macvlan and internal", func(t *testing.T) {
+		config, err := parseNetworkOptions("net1", map[string]interface{}{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if config.NetworkType != macvlanType {
+			t.Errorf("NetworkType = %q, want %q", config.NetworkType, macvlanType)
+		}
+		if !config.Internal {
+			t.Errorf("Internal = false, want true when no options are given")
+		}
+	})
+
+	t.Run("empty parent implies internal", func(t *testing.T) {
+		opts := map[string]string{}
+		config, err := parseNetworkOptions("net1", map[string]interface{}{netlabel.GenericData: opts})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !config.Internal {
+			t.Errorf("Internal = false, want true when parent is empty")
+		}
+	})
+
+	t.Run("explicit parent and driver type", func(t *testing.T) {
+		opts := map[string]string{
+			driverOpt: ipvlanType,
+			parentOpt: "eth0.40",
+		}
+		config, err := parseNetworkOptions("net1", map[string]interface{}{netlabel.GenericData: opts})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if config.NetworkType != ipvlanType {
+			t.Errorf("NetworkType = %q, want %q", config.NetworkType, ipvlanType)
+		}
+		if config.Parent != "eth0.40" {
+			t.Errorf("Parent = %q, want eth0.40", config.Parent)
+		}
+		if config.Internal {
+			t.Errorf("Internal = true, want false when parent is set")
+		}
+	})
+
+	t.Run("explicit internal option overrides a non-empty parent", func(t *testing.T) {
+		opts := map[string]string{
+			parentOpt:   "eth0",
+			internalOpt: "true",
+		}
+		config, err := parseNetworkOptions("net1", map[string]interface{}{netlabel.GenericData: opts})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !config.Internal {
+			t.Errorf("Internal = false, want true")
+		}
+	})
+
+	t.Run("invalid internal value errors", func(t *testing.T) {
+		opts := map[string]string{internalOpt: "not-a-bool"}
+		if _, err := parseNetworkOptions("net1", map[string]interface{}{netlabel.GenericData: opts}); err == nil {
+			t.Error("expected error for invalid internal option, got nil")
+		}
+	})
+
+	t.Run("malformed generic data errors", func(t *testing.T) {
+		if _, err := parseNetworkOptions("net1", map[string]interface{}{netlabel.GenericData: "not-a-map"}); err == nil {
+			t.Error("expected error for malformed generic options, got nil")
+		}
+	})
+}
+
+func TestCheckKernelVersion(t *testing.T) {
+	old := kernel.VersionInfo{Kernel: 3, Major: 10}
+	floor := kernel.VersionInfo{Kernel: ipvlanKernelMajor, Major: ipvlanKernelMinor}
+	l3Floor := kernel.VersionInfo{Kernel: ipvlanL3KernelMajor, Major: ipvlanL3KernelMinor}
+
+	cases := []struct {
+		name    string
+		config  *configuration
+		kv      kernel.VersionInfo
+		wantErr bool
+	}{
+		{"macvlan is never gated on kernel version", &configuration{NetworkType: macvlanType}, old, false},
+		{"ipvlan l2/bubble at the 4.2 floor is fine", &configuration{NetworkType: ipvlanType, IpvlanMode: modeL2, IpvlanFlag: flagBubble}, floor, false},
+		{"ipvlan l2/bubble below the 4.2 floor fails", &configuration{NetworkType: ipvlanType, IpvlanMode: modeL2, IpvlanFlag: flagBubble}, old, true},
+		{"ipvlan l3s below the 4.9 floor fails even past 4.2", &configuration{NetworkType: ipvlanType, IpvlanMode: modeL3S, IpvlanFlag: flagBubble}, floor, true},
+		{"ipvlan l3 at the 4.9 floor is fine", &configuration{NetworkType: ipvlanType, IpvlanMode: modeL3, IpvlanFlag: flagBubble}, l3Floor, false},
+		{"non-default ipvlan flag requires the 4.9 floor", &configuration{NetworkType: ipvlanType, IpvlanMode: modeL2, IpvlanFlag: modePrivate}, floor, true},
+	}
+
+	for _, c := range cases {
+		err := c.config.checkKernelVersion(c.kv)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: checkKernelVersion() error = %v, wantErr %v", c.name, err, c.wantErr)
+		}
+	}
+}
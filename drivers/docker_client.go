@@ -0,0 +1,82 @@
+package drivers
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// defaultDockerHost is used when neither Config.DockerHost nor DOCKER_HOST
+// is set, matching the standalone (non-swarm) default.
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+// Config carries the Docker/swarm client settings the driver's main package
+// wires up at startup. The zero value falls back to the local Docker socket
+// with no TLS.
+type Config struct {
+	DockerHost  string // e.g. unix:///var/run/docker.sock or tcp://host:2376
+	TLSCertPath string
+	TLSKeyPath  string
+	TLSCAPath   string
+	Timeout     time.Duration
+}
+
+// dockerClient lazily dials the configured Docker daemon the first time a
+// code path actually needs cluster/swarm information, rather than failing
+// the whole plugin at startup if the socket isn't reachable yet. A failed
+// dial is cached nowhere, so the next caller retries and gets the error
+// again instead of silently reusing a nil client.
+func (d *Driver) dockerClient() (*docker.Client, error) {
+	d.Lock()
+	client := d.Client
+	d.Unlock()
+	if client != nil {
+		return client, nil
+	}
+
+	client, err := newDockerClient(d.dockerCfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to docker for swarm features: %v", err)
+	}
+
+	d.Lock()
+	if d.Client == nil {
+		d.Client = client
+	} else {
+		client = d.Client
+	}
+	d.Unlock()
+
+	return client, nil
+}
+
+func newDockerClient(cfg Config) (*docker.Client, error) {
+	host := cfg.DockerHost
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = defaultDockerHost
+	}
+
+	var (
+		client *docker.Client
+		err    error
+	)
+	if cfg.TLSCertPath != "" {
+		client, err = docker.NewTLSClient(host, cfg.TLSCertPath, cfg.TLSKeyPath, cfg.TLSCAPath)
+	} else {
+		client, err = docker.NewClient(host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not create docker client for %s: %v", host, err)
+	}
+
+	if cfg.Timeout > 0 {
+		client.SetTimeout(cfg.Timeout)
+	}
+
+	return client, nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/docker/docker/pkg/stringid"
 	pluginNet "github.com/docker/go-plugins-helpers/network"
 	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/ns"
 	"github.com/docker/libnetwork/osl"
 	docker "github.com/fsouza/go-dockerclient"
 )
@@ -23,7 +24,12 @@ const (
 	modePassthru        = "passthru" // macvlan mode passthrough
 	parentOpt           = "parent"   // parent interface -o parent
 	modeOpt             = "_mode"    // macvlan mode ux opt suffix
-	swarmEndpoint       = "http://localhost:6732"
+
+	ipvlanType       = "ipvlan" // driver type name
+	modeL2           = "l2"     // ipvlan mode L2 (default)
+	modeL3           = "l3"     // ipvlan mode L3
+	modeL3S          = "l3s"    // ipvlan mode L3S (routed, iptables visible)
+	dummyIfacePrefix = "dm-"    // prefix for internal-mode dummy parent links
 )
 
 var driverModeOpt = macvlanType + modeOpt // mode --option macvlan_mode
@@ -35,10 +41,10 @@ type NetworkTable map[string]*network
 
 // Driver ...
 type Driver struct {
-	Networks NetworkTable
-	Store    MacStore
-	Client   *docker.Client
-	sync.Once
+	Networks  NetworkTable
+	Store     MacStore
+	Client    *docker.Client
+	dockerCfg Config
 	sync.Mutex
 }
 
@@ -51,25 +57,32 @@ type network struct {
 	sync.Mutex
 }
 
-// Init macvlan remote driver
-func Init(ms MacStore) (*Driver, error) {
+// Init macvlan remote driver. cfg configures the (lazily connected) Docker
+// client used for swarm/cluster features; activeSandboxes maps network IDs
+// to a libnetwork-supplied descriptor of container sandboxes that were
+// already live before this driver instance started, so a plugin restart can
+// re-associate them instead of stranding their networking.
+func Init(ms MacStore, cfg Config, activeSandboxes map[string]interface{}) (*Driver, error) {
 	d := &Driver{
-		Networks: NetworkTable{},
-		Store:    ms,
+		Networks:  NetworkTable{},
+		Store:     ms,
+		dockerCfg: cfg,
 	}
 
-	var err error
-
-	if err = d.Store.InitStore(d); err != nil {
+	if err := d.Store.InitStore(d); err != nil {
 		logrus.Debugf("Failure during init macvlan local store : %v", err)
 		return nil, fmt.Errorf("Failure during init macvlan local store . Error: %s", err)
 	}
 
-	d.Client, err = docker.NewClient(swarmEndpoint)
-	if err != nil {
-		logrus.Debugf("Could not connect to swarm. Error: %v", err)
-		return nil, fmt.Errorf("could not connect to swarm. Error: %s", err)
+	if err := d.populateNetworks(); err != nil {
+		logrus.Debugf("Failure repopulating macvlan networks from store : %v", err)
+		return nil, fmt.Errorf("failure repopulating macvlan networks from store. Error: %s", err)
+	}
+	if err := d.populateEndpoints(); err != nil {
+		logrus.Debugf("Failure repopulating macvlan endpoints from store : %v", err)
+		return nil, fmt.Errorf("failure repopulating macvlan endpoints from store. Error: %s", err)
 	}
+	d.reconcileSandboxes(activeSandboxes)
 
 	return d, nil
 }
@@ -119,30 +132,89 @@ func (d *Driver) AllocateNetwork(r *pluginNet.AllocateNetworkRequest) (*pluginNe
 		return nil, err
 	}
 
-	// verify the macvlan mode from -o macvlan_mode option
-	switch config.MacvlanMode {
-	case "", modeBridge:
-		// default to macvlan bridge mode if -o macvlan_mode is empty
-		config.MacvlanMode = modeBridge
-	case modePrivate:
-		config.MacvlanMode = modePrivate
-	case modePassthru:
-		config.MacvlanMode = modePassthru
-	case modeVepa:
-		config.MacvlanMode = modeVepa
+	switch config.NetworkType {
+	case macvlanType:
+		// verify the macvlan mode from -o macvlan_mode option
+		switch config.MacvlanMode {
+		case "", modeBridge:
+			// default to macvlan bridge mode if -o macvlan_mode is empty
+			config.MacvlanMode = modeBridge
+		case modePrivate:
+			config.MacvlanMode = modePrivate
+		case modePassthru:
+			config.MacvlanMode = modePassthru
+		case modeVepa:
+			config.MacvlanMode = modeVepa
+		default:
+			return nil, fmt.Errorf("requested macvlan mode '%s' is not valid, 'bridge' mode is the macvlan driver default", config.MacvlanMode)
+		}
+	case ipvlanType:
+		// verify the ipvlan mode from -o ipvlan_mode option
+		switch config.IpvlanMode {
+		case "", modeL2:
+			// default to ipvlan L2 mode if -o ipvlan_mode is empty
+			config.IpvlanMode = modeL2
+		case modeL3:
+			config.IpvlanMode = modeL3
+		case modeL3S:
+			config.IpvlanMode = modeL3S
+		default:
+			return nil, fmt.Errorf("requested ipvlan mode '%s' is not valid, 'l2' mode is the ipvlan driver default", config.IpvlanMode)
+		}
+		// verify the ipvlan flag from -o ipvlan_flag option
+		switch config.IpvlanFlag {
+		case "", flagBubble:
+			config.IpvlanFlag = flagBubble
+		case modePrivate, modeVepa:
+			// ipvlan_flag reuses the private/vepa literals already defined for macvlan
+		default:
+			return nil, fmt.Errorf("requested ipvlan flag '%s' is not valid, must be one of bubble, private, vepa", config.IpvlanFlag)
+		}
 	default:
-		return nil, fmt.Errorf("requested macvlan mode '%s' is not valid, 'bridge' mode is the macvlan driver default", config.MacvlanMode)
+		return nil, fmt.Errorf("unsupported network type '%s', must be '%s' or '%s'", config.NetworkType, macvlanType, ipvlanType)
+	}
+
+	if err := config.checkKernelCompatibility(); err != nil {
+		return nil, err
 	}
+
 	// loopback is not a valid parent link
 	if config.Parent == "lo" {
-		return nil, fmt.Errorf("loopback interface is not a valid %s parent link", macvlanType)
+		return nil, fmt.Errorf("loopback interface is not a valid %s parent link", config.NetworkType)
+	}
+
+	// reject a duplicate parent before creating any host-side link for it,
+	// so a rejected network never leaks a freshly created vlan/dummy link
+	if !config.Internal {
+		if dup := findDuplicateParent(d.getNetworks(), config.Parent); dup != nil {
+			return nil, fmt.Errorf("network %s is already using parent interface %s", dup.config.ID, config.Parent)
+		}
 	}
 
-	networkList := d.getNetworks()
-	for _, nw := range networkList {
-		if config.Parent == nw.config.Parent {
-			return nil, fmt.Errorf("network %s is already using parent interface %s",
-				getDummyName(stringid.TruncateID(nw.config.ID)), config.Parent)
+	switch {
+	case config.Internal:
+		// no physical parent: back the network with a host dummy link
+		if err := createDummyLink(config, getDummyName(stringid.TruncateID(id))); err != nil {
+			return nil, err
+		}
+	default:
+		if base, vlanID, tagged := parseVlanParent(config.Parent); tagged {
+			if err := validateVlanID(vlanID); err != nil {
+				return nil, fmt.Errorf("%v for parent %s", err, config.Parent)
+			}
+			if _, err := ns.NlHandle().LinkByName(base); err != nil {
+				return nil, fmt.Errorf("vlan parent interface %s does not exist: %v", base, err)
+			}
+			config.ParentIface = base
+			config.VlanID = vlanID
+
+			if _, err := ns.NlHandle().LinkByName(config.Parent); err != nil {
+				// sub-interface doesn't exist yet: create and own it
+				if err := createVlanLink(config); err != nil {
+					return nil, err
+				}
+				config.CreatedVlanLink = true
+			}
 		}
 	}
 
@@ -152,6 +224,16 @@ func (d *Driver) AllocateNetwork(r *pluginNet.AllocateNetworkRequest) (*pluginNe
 		config: config,
 	}
 
+	if err := d.storeUpdate(config); err != nil {
+		if config.CreatedVlanLink {
+			deleteVlanLink(config)
+		}
+		if config.CreatedDummyLink {
+			deleteDummyLink(config)
+		}
+		return nil, fmt.Errorf("failed to save %s network %s to store: %v", config.NetworkType, id, err)
+	}
+
 	d.Lock()
 	d.Networks[id] = n
 	d.Unlock()
@@ -170,7 +252,7 @@ func (d *Driver) FreeNetwork(r *pluginNet.FreeNetworkRequest) error {
 	}
 
 	d.Lock()
-	_, ok := d.Networks[id]
+	n, ok := d.Networks[id]
 	d.Unlock()
 
 	if !ok {
@@ -178,6 +260,31 @@ func (d *Driver) FreeNetwork(r *pluginNet.FreeNetworkRequest) error {
 		return nil
 	}
 
+	if n.config.CreatedVlanLink || n.config.CreatedDummyLink {
+		n.Lock()
+		slaveCount := len(n.endpoints)
+		n.Unlock()
+		if slaveCount > 0 {
+			return fmt.Errorf("network %s still has %d endpoint(s) attached to parent %s", id, slaveCount, n.config.Parent)
+		}
+	}
+
+	if n.config.CreatedVlanLink {
+		if err := deleteVlanLink(n.config); err != nil {
+			logrus.Warnf("failed to remove vlan sub-interface %s for network %s: %v", n.config.Parent, id, err)
+		}
+	}
+
+	if n.config.CreatedDummyLink {
+		if err := deleteDummyLink(n.config); err != nil {
+			logrus.Warnf("failed to remove dummy parent link %s for network %s: %v", n.config.Parent, id, err)
+		}
+	}
+
+	if err := d.storeDelete(n.config); err != nil {
+		logrus.Warnf("failed to remove %s network %s from store: %v", n.config.NetworkType, id, err)
+	}
+
 	d.Lock()
 	delete(d.Networks, id)
 	d.Unlock()
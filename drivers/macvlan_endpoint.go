@@ -1,6 +1,7 @@
 package drivers
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 
@@ -40,7 +41,9 @@ func (d *Driver) CreateEndpoint(r *pluginNet.CreateEndpointRequest) (*pluginNet.
 	if intf == nil {
 		return nil, fmt.Errorf("invalid interface passed while create macvlan endpoint")
 	}
-	n, ok := d.networks[networkID]
+	d.Lock()
+	n, ok := d.Networks[networkID]
+	d.Unlock()
 	if !ok {
 		return nil, fmt.Errorf("macvlan network with id %s not found", networkID)
 	}
@@ -85,12 +88,12 @@ func (d *Driver) CreateEndpoint(r *pluginNet.CreateEndpointRequest) (*pluginNet.
 	}
 
 	if err := d.storeUpdate(ep); err != nil {
-		return fmt.Errorf("failed to save macvlan endpoint %s to store: %v", ep.id[0:7], err)
+		return nil, fmt.Errorf("failed to save macvlan endpoint %s to store: %v", ep.id[0:7], err)
 	}
 
 	n.addEndpoint(ep)
 
-	epResponse := &pluginNet.CreateEndpointResponse{Interface: &pluginNet.EndpointInterface{"", "", intf.MacAddress}}
+	epResponse := &pluginNet.CreateEndpointResponse{Interface: &pluginNet.EndpointInterface{MacAddress: intf.MacAddress}}
 	return epResponse, nil
 }
 
@@ -106,11 +109,15 @@ func (d *Driver) DeleteEndpoint(r *pluginNet.DeleteEndpointRequest) error {
 	if eid == "" {
 		return fmt.Errorf("invalid endpoint id")
 	}
-	n := d.networks[nid]
+	d.Lock()
+	n := d.Networks[nid]
+	d.Unlock()
 	if n == nil {
 		return fmt.Errorf("network id %q not found", nid)
 	}
+	n.Lock()
 	ep := n.endpoints[eid]
+	n.Unlock()
 	if ep == nil {
 		return fmt.Errorf("endpoint id %q not found", eid)
 	}
@@ -121,6 +128,189 @@ func (d *Driver) DeleteEndpoint(r *pluginNet.DeleteEndpointRequest) error {
 	return nil
 }
 
+// Join creates the macvlan/ipvlan slave device for the endpoint and hands its
+// name back to libnetwork so it can be moved into the container sandbox.
+func (d *Driver) Join(r *pluginNet.JoinRequest) (*pluginNet.JoinResponse, error) {
+	logrus.Debugf("Join macvlan")
+	defer osl.InitOSContext()()
+	nid := r.NetworkID
+	eid := r.EndpointID
+
+	d.Lock()
+	n, ok := d.Networks[nid]
+	d.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("network id %q not found", nid)
+	}
+
+	n.Lock()
+	ep, ok := n.endpoints[eid]
+	n.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("endpoint id %q not found", eid)
+	}
+
+	// reuse ep.srcName if its slave link is still around (e.g. a repeat
+	// Join), or recreate it under the same name if a restart dropped it;
+	// only fall back to a freshly generated name when there was none. The
+	// daemon moves whatever we return into the sandbox for us, so we don't
+	// need to know here whether the link was just (re)created.
+	ifaceName, _, err := n.ensureSlaveLink(ep, ep.srcName)
+	if err != nil {
+		return nil, err
+	}
+
+	if ifaceName != ep.srcName {
+		ep.srcName = ifaceName
+		if err := d.storeUpdate(ep); err != nil {
+			logrus.Warnf("failed to update macvlan endpoint %s in store: %v", ep.id[0:7], err)
+		}
+	}
+
+	joinResponse := &pluginNet.JoinResponse{
+		InterfaceName: pluginNet.InterfaceName{
+			SrcName:   ifaceName,
+			DstPrefix: containerVethPrefix,
+		},
+	}
+
+	if s := n.getSubnetforIP(ep.addr); s != nil && s.GwIP != "" {
+		if gw, _, err := net.ParseCIDR(s.GwIP); err == nil {
+			joinResponse.Gateway = gw.String()
+		}
+	}
+
+	return joinResponse, nil
+}
+
+// Leave removes the macvlan/ipvlan slave device created for the endpoint in Join.
+func (d *Driver) Leave(r *pluginNet.LeaveRequest) error {
+	logrus.Debugf("Leave macvlan")
+	defer osl.InitOSContext()()
+	nid := r.NetworkID
+	eid := r.EndpointID
+
+	d.Lock()
+	n, ok := d.Networks[nid]
+	d.Unlock()
+	if !ok {
+		return fmt.Errorf("network id %q not found", nid)
+	}
+
+	n.Lock()
+	ep, ok := n.endpoints[eid]
+	n.Unlock()
+	if !ok {
+		return fmt.Errorf("endpoint id %q not found", eid)
+	}
+
+	if ep.srcName == "" {
+		return nil
+	}
+
+	link, err := ns.NlHandle().LinkByName(ep.srcName)
+	if err != nil {
+		logrus.Debugf("%s slave link %s for endpoint %s already removed", macvlanType, ep.srcName, eid)
+		return nil
+	}
+	if err := ns.NlHandle().LinkDel(link); err != nil {
+		return fmt.Errorf("failed to remove %s slave link %s: %v", macvlanType, ep.srcName, err)
+	}
+
+	return nil
+}
+
+// endpointPrefix namespaces endpoint records in the store, independent of
+// whether their network is macvlan or ipvlan, so they can all be listed
+// together on restart.
+const endpointPrefix = "endpoint"
+
+// Key implements KVObject, keying the record as endpoint/<network>/<endpoint>.
+func (ep *endpoint) Key() []string {
+	return []string{endpointPrefix, ep.nid, ep.id}
+}
+
+// endpointRecord is the on-disk shape of an endpoint, since endpoint itself
+// keeps its fields unexported.
+type endpointRecord struct {
+	ID      string `json:"id"`
+	NID     string `json:"nid"`
+	MAC     string `json:"mac,omitempty"`
+	Addr    string `json:"addr,omitempty"`
+	AddrV6  string `json:"addrv6,omitempty"`
+	SrcName string `json:"src_name,omitempty"`
+}
+
+// Value implements KVObject.
+func (ep *endpoint) Value() []byte {
+	rec := endpointRecord{ID: ep.id, NID: ep.nid, SrcName: ep.srcName}
+	if ep.mac != nil {
+		rec.MAC = ep.mac.String()
+	}
+	if ep.addr != nil {
+		rec.Addr = ep.addr.String()
+	}
+	if ep.addrv6 != nil {
+		rec.AddrV6 = ep.addrv6.String()
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// SetValue implements KVObject.
+func (ep *endpoint) SetValue(value []byte) error {
+	var rec endpointRecord
+	if err := json.Unmarshal(value, &rec); err != nil {
+		return err
+	}
+
+	ep.id = rec.ID
+	ep.nid = rec.NID
+	ep.srcName = rec.SrcName
+	if rec.MAC != "" {
+		if mac, err := net.ParseMAC(rec.MAC); err == nil {
+			ep.mac = mac
+		}
+	}
+	if rec.Addr != "" {
+		if _, addr, err := net.ParseCIDR(rec.Addr); err == nil {
+			ep.addr = addr
+		}
+	}
+	if rec.AddrV6 != "" {
+		if _, addr, err := net.ParseCIDR(rec.AddrV6); err == nil {
+			ep.addrv6 = addr
+		}
+	}
+
+	return nil
+}
+
+// Index implements KVObject.
+func (ep *endpoint) Index() uint64 {
+	return ep.dbIndex
+}
+
+// SetIndex implements KVObject.
+func (ep *endpoint) SetIndex(index uint64) {
+	ep.dbIndex = index
+	ep.dbExists = true
+}
+
+// Exists implements KVObject.
+func (ep *endpoint) Exists() bool {
+	return ep.dbExists
+}
+
+// SetExists implements KVObject.
+func (ep *endpoint) SetExists(exists bool) {
+	ep.dbExists = exists
+}
+
 func (d *Driver) deleteEndpoint(n *network, ep *endpoint) error {
 	if link, err := ns.NlHandle().LinkByName(ep.srcName); err == nil {
 		ns.NlHandle().LinkDel(link)
@@ -0,0 +1,185 @@
+package drivers
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/ns"
+	"github.com/docker/libnetwork/osl"
+)
+
+// populateNetworks rebuilds d.Networks from the store on startup, so a
+// plugin restart does not strand existing container networking.
+func (d *Driver) populateNetworks() error {
+	if d.Store == nil {
+		return nil
+	}
+
+	for _, networkType := range []string{macvlanType, ipvlanType} {
+		kvObjects, err := d.Store.List(networkType, func() KVObject { return &configuration{} })
+		if err != nil {
+			logrus.Debugf("no %s networks found in store: %v", networkType, err)
+			continue
+		}
+
+		for _, kv := range kvObjects {
+			config, ok := kv.(*configuration)
+			if !ok {
+				continue
+			}
+
+			if err := restoreParentLink(config); err != nil {
+				logrus.Warnf("failed to restore parent link %s for network %s: %v", config.Parent, config.ID, err)
+			}
+
+			n := &network{id: config.ID, driver: d, config: config}
+			d.Lock()
+			d.Networks[config.ID] = n
+			d.Unlock()
+		}
+	}
+
+	return nil
+}
+
+// populateEndpoints rebuilds each network's endpoint table from the store on
+// startup.
+func (d *Driver) populateEndpoints() error {
+	if d.Store == nil {
+		return nil
+	}
+
+	kvObjects, err := d.Store.List(endpointPrefix, func() KVObject { return &endpoint{} })
+	if err != nil {
+		logrus.Debugf("no macvlan endpoints found in store: %v", err)
+		return nil
+	}
+
+	for _, kv := range kvObjects {
+		ep, ok := kv.(*endpoint)
+		if !ok {
+			continue
+		}
+
+		d.Lock()
+		n, ok := d.Networks[ep.nid]
+		d.Unlock()
+		if !ok {
+			logrus.Warnf("endpoint %s references unknown network %s, dropping stale record", ep.id, ep.nid)
+			d.storeDelete(ep)
+			continue
+		}
+		n.addEndpoint(ep)
+
+		if ep.srcName == "" {
+			continue
+		}
+		if _, err := ns.NlHandle().LinkByName(ep.srcName); err == nil {
+			continue
+		}
+		logrus.Warnf("slave link %s for endpoint %s missing after restart, recreating", ep.srcName, ep.id)
+		// the container sandbox isn't known yet at this point in startup
+		// (reconcileSandboxes pairs them up afterwards), so the recreated
+		// link is left on the host; reconcileSandboxes is responsible for
+		// moving it into the sandbox once it has one to move it into.
+		if _, _, err := n.ensureSlaveLink(ep, ep.srcName); err != nil {
+			logrus.Warnf("failed to recreate slave link %s for endpoint %s: %v", ep.srcName, ep.id, err)
+		}
+	}
+
+	return nil
+}
+
+// restoreParentLink re-verifies (or recreates) the parent link a network
+// depends on after a restart: the dummy link for internal networks, or the
+// 802.1q sub-interface for vlan-tagged parents.
+func restoreParentLink(config *configuration) error {
+	if config.Parent == "" {
+		return nil
+	}
+
+	if _, err := ns.NlHandle().LinkByName(config.Parent); err == nil {
+		return nil
+	}
+
+	switch {
+	case config.CreatedDummyLink:
+		return createDummyLink(config, config.Parent)
+	case config.CreatedVlanLink:
+		return createVlanLink(config)
+	default:
+		// the user-supplied parent is missing; leave it to AllocateNetwork's
+		// validation to surface this the next time the network is touched.
+		return nil
+	}
+}
+
+// reconcileSandboxes re-associates any container sandboxes that were already
+// live for a network when this driver instance started, e.g. across a
+// `systemctl restart`, instead of leaving them stranded. activeSandboxes
+// maps network ID to the sandbox key (network namespace path) libnetwork
+// handed the previous driver instance for that network.
+func (d *Driver) reconcileSandboxes(activeSandboxes map[string]interface{}) {
+	for nid, sboxData := range activeSandboxes {
+		d.Lock()
+		n, ok := d.Networks[nid]
+		d.Unlock()
+		if !ok {
+			logrus.Warnf("active sandbox references unknown network %s, skipping reconciliation", nid)
+			continue
+		}
+
+		key, ok := sboxData.(string)
+		if !ok || key == "" {
+			logrus.Warnf("active sandbox for network %s has no usable key, skipping reconciliation", nid)
+			continue
+		}
+
+		sbox, err := osl.GetSandboxForExternalKey(key, key)
+		if err != nil {
+			logrus.Warnf("failed to re-associate sandbox %s for network %s: %v", key, nid, err)
+			continue
+		}
+
+		n.Lock()
+		n.sbox = sbox
+		endpoints := make([]*endpoint, 0, len(n.endpoints))
+		for _, ep := range n.endpoints {
+			endpoints = append(endpoints, ep)
+		}
+		n.Unlock()
+
+		for _, ep := range endpoints {
+			if ep.srcName == "" {
+				continue
+			}
+			ifaceName, _, err := n.ensureSlaveLink(ep, ep.srcName)
+			if err != nil {
+				logrus.Warnf("failed to reconcile slave link %s for endpoint %s on network %s: %v", ep.srcName, ep.id, nid, err)
+				continue
+			}
+
+			// ensureSlaveLink looks the link up on the host, so the link it
+			// returns, whether reused (e.g. recreated by populateEndpoints)
+			// or newly created, is outside the sandbox; without this the
+			// container is left with no usable interface even though
+			// "reconciliation" ran.
+			var ifaceOpts []osl.IfaceOption
+			if ep.addr != nil {
+				ifaceOpts = append(ifaceOpts, osl.WithIPv4Address(ep.addr))
+			}
+			if ep.addrv6 != nil {
+				ifaceOpts = append(ifaceOpts, osl.WithIPv6Address(ep.addrv6))
+			}
+			if err := sbox.AddInterface(ifaceName, containerVethPrefix, ifaceOpts...); err != nil {
+				logrus.Warnf("failed to move recreated slave link %s for endpoint %s on network %s into its sandbox: %v", ifaceName, ep.id, nid, err)
+				continue
+			}
+
+			ep.srcName = ifaceName
+			if err := d.storeUpdate(ep); err != nil {
+				logrus.Warnf("failed to update macvlan endpoint %s in store: %v", ep.id[0:7], err)
+			}
+		}
+
+		logrus.Debugf("re-associated live sandbox %s for network %s after restart", key, nid)
+	}
+}
@@ -0,0 +1,263 @@
+package drivers
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/ns"
+	"github.com/vishvananda/netlink"
+)
+
+// getNetworks returns a snapshot of the driver's currently known networks.
+func (d *Driver) getNetworks() []*network {
+	d.Lock()
+	defer d.Unlock()
+
+	networks := make([]*network, 0, len(d.Networks))
+	for _, n := range d.Networks {
+		networks = append(networks, n)
+	}
+
+	return networks
+}
+
+// getSubnetforIP returns the configured subnet that contains ip, or nil if
+// none of the network's bound pools match.
+func (n *network) getSubnetforIP(ip *net.IPNet) *ipSubnet {
+	for _, s := range n.config.Ifaces {
+		_, subnet, err := net.ParseCIDR(s.SubnetIP)
+		if err != nil {
+			continue
+		}
+		if subnet.Contains(ip.IP) {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// addEndpoint registers ep on the network's endpoint table.
+func (n *network) addEndpoint(ep *endpoint) {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.endpoints == nil {
+		n.endpoints = endpointTable{}
+	}
+	n.endpoints[ep.id] = ep
+}
+
+// deleteEndpoint removes the endpoint with the given id from the network's
+// endpoint table.
+func (n *network) deleteEndpoint(eid string) {
+	n.Lock()
+	defer n.Unlock()
+
+	delete(n.endpoints, eid)
+}
+
+// findDuplicateParent returns the network already bound to parent, if any,
+// so callers can reject a second network claiming the same host link.
+func findDuplicateParent(networks []*network, parent string) *network {
+	for _, nw := range networks {
+		if nw.config.Parent == parent {
+			return nw
+		}
+	}
+
+	return nil
+}
+
+// getDummyName returns the host-side link name used for a network's
+// internal (parent-less) dummy interface.
+func getDummyName(truncatedID string) string {
+	return dummyIfacePrefix + truncatedID
+}
+
+// createVlanLink creates the 802.1q tagged sub-interface named by
+// config.Parent on top of config.ParentIface, per the "parent=eth0.40"
+// convention. It is a no-op error if the base interface is missing.
+func createVlanLink(config *configuration) error {
+	parent, err := ns.NlHandle().LinkByName(config.ParentIface)
+	if err != nil {
+		return fmt.Errorf("vlan parent interface %s not found: %v", config.ParentIface, err)
+	}
+
+	vlan := &netlink.Vlan{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:        config.Parent,
+			ParentIndex: parent.Attrs().Index,
+		},
+		VlanId: config.VlanID,
+	}
+	if err := ns.NlHandle().LinkAdd(vlan); err != nil {
+		return fmt.Errorf("failed to create vlan sub-interface %s on %s: %v", config.Parent, config.ParentIface, err)
+	}
+	if err := ns.NlHandle().LinkSetUp(vlan); err != nil {
+		return fmt.Errorf("failed to set vlan sub-interface %s up: %v", config.Parent, err)
+	}
+
+	return nil
+}
+
+// deleteVlanLink removes the 802.1q sub-interface named by config.Parent. It
+// is only safe to call when config.CreatedVlanLink is true.
+func deleteVlanLink(config *configuration) error {
+	link, err := ns.NlHandle().LinkByName(config.Parent)
+	if err != nil {
+		// already gone
+		return nil
+	}
+
+	return ns.NlHandle().LinkDel(link)
+}
+
+// createDummyLink creates the host dummy interface backing an internal
+// (parent-less) network and points config.Parent at it.
+func createDummyLink(config *configuration, dummyName string) error {
+	dummy := &netlink.Dummy{
+		LinkAttrs: netlink.LinkAttrs{Name: dummyName},
+	}
+	if err := ns.NlHandle().LinkAdd(dummy); err != nil {
+		return fmt.Errorf("failed to create dummy parent link %s: %v", dummyName, err)
+	}
+	if err := ns.NlHandle().LinkSetUp(dummy); err != nil {
+		return fmt.Errorf("failed to set dummy parent link %s up: %v", dummyName, err)
+	}
+
+	config.Parent = dummyName
+	config.CreatedDummyLink = true
+
+	return nil
+}
+
+// deleteDummyLink removes the dummy parent link backing an internal network.
+// It is only safe to call when config.CreatedDummyLink is true.
+func deleteDummyLink(config *configuration) error {
+	link, err := ns.NlHandle().LinkByName(config.Parent)
+	if err != nil {
+		// already gone
+		return nil
+	}
+
+	return ns.NlHandle().LinkDel(link)
+}
+
+// parentLinkIndex resolves the host link index that slave devices for this
+// network should be created against.
+func (n *network) parentLinkIndex() (int, error) {
+	link, err := ns.NlHandle().LinkByName(n.config.Parent)
+	if err != nil {
+		return 0, fmt.Errorf("parent interface %s not found for network %s: %v", n.config.Parent, n.id, err)
+	}
+
+	return link.Attrs().Index, nil
+}
+
+// createSlaveLink creates the per-endpoint macvlan/ipvlan slave device named
+// ifaceName against parentIndex, choosing the netlink link type and mode
+// from the network's configuration.
+func createSlaveLink(config *configuration, parentIndex int, ifaceName string) (netlink.Link, error) {
+	var link netlink.Link
+
+	switch config.NetworkType {
+	case ipvlanType:
+		link = &netlink.IPVlan{
+			LinkAttrs: netlink.LinkAttrs{Name: ifaceName, ParentIndex: parentIndex},
+			Mode:      ipvlanNetlinkMode(config.IpvlanMode),
+			Flag:      ipvlanNetlinkFlag(config.IpvlanFlag),
+		}
+	default:
+		link = &netlink.Macvlan{
+			LinkAttrs: netlink.LinkAttrs{Name: ifaceName, ParentIndex: parentIndex},
+			Mode:      macvlanNetlinkMode(config.MacvlanMode),
+		}
+	}
+
+	if err := ns.NlHandle().LinkAdd(link); err != nil {
+		return nil, fmt.Errorf("failed to create %s slave interface %s: %v", config.NetworkType, ifaceName, err)
+	}
+	if err := ns.NlHandle().LinkSetUp(link); err != nil {
+		return nil, fmt.Errorf("failed to set %s slave interface %s up: %v", config.NetworkType, ifaceName, err)
+	}
+
+	return link, nil
+}
+
+// ensureSlaveLink makes sure a macvlan/ipvlan slave device for ep exists on
+// network n, reusing preferredName (ep's previously stored srcName) if that
+// link is still present, recreating it under the same name if it has gone
+// missing (e.g. across a plugin restart), or generating a fresh name when
+// preferredName is empty. It returns the interface name now in use and
+// whether a new link was created (as opposed to the existing one being
+// reused).
+func (n *network) ensureSlaveLink(ep *endpoint, preferredName string) (string, bool, error) {
+	if preferredName != "" {
+		if _, err := ns.NlHandle().LinkByName(preferredName); err == nil {
+			return preferredName, false, nil
+		}
+	}
+
+	ifaceName := preferredName
+	if ifaceName == "" {
+		var err error
+		ifaceName, err = netutils.GenerateIfaceName(ns.NlHandle(), vethPrefix, vethLen)
+		if err != nil {
+			return "", false, fmt.Errorf("error generating an interface name: %s", err)
+		}
+	}
+
+	parentIndex, err := n.parentLinkIndex()
+	if err != nil {
+		return "", false, err
+	}
+
+	slaveLink, err := createSlaveLink(n.config, parentIndex, ifaceName)
+	if err != nil {
+		return "", false, err
+	}
+
+	if err := ns.NlHandle().LinkSetHardwareAddr(slaveLink, ep.mac); err != nil {
+		ns.NlHandle().LinkDel(slaveLink)
+		return "", false, fmt.Errorf("failed to set mac address on %s: %v", ifaceName, err)
+	}
+
+	return ifaceName, true, nil
+}
+
+func macvlanNetlinkMode(mode string) netlink.MacvlanMode {
+	switch mode {
+	case modePrivate:
+		return netlink.MACVLAN_MODE_PRIVATE
+	case modeVepa:
+		return netlink.MACVLAN_MODE_VEPA
+	case modePassthru:
+		return netlink.MACVLAN_MODE_PASSTHRU
+	default:
+		return netlink.MACVLAN_MODE_BRIDGE
+	}
+}
+
+func ipvlanNetlinkMode(mode string) netlink.IPVlanMode {
+	switch mode {
+	case modeL3:
+		return netlink.IPVLAN_MODE_L3
+	case modeL3S:
+		return netlink.IPVLAN_MODE_L3S
+	default:
+		return netlink.IPVLAN_MODE_L2
+	}
+}
+
+func ipvlanNetlinkFlag(flag string) netlink.IPVlanFlag {
+	switch flag {
+	case modePrivate:
+		return netlink.IPVLAN_FLAG_PRIVATE
+	case modeVepa:
+		return netlink.IPVLAN_FLAG_VEPA
+	default:
+		return netlink.IPVLAN_FLAG_BRIDGE
+	}
+}
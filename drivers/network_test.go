@@ -0,0 +1,20 @@
+package drivers
+
+import "testing"
+
+func TestFindDuplicateParent(t *testing.T) {
+	existing := &network{id: "net1", config: &configuration{ID: "net1", Parent: "eth0.40"}}
+	networks := []*network{existing}
+
+	if dup := findDuplicateParent(networks, "eth0.40"); dup != existing {
+		t.Errorf("findDuplicateParent() = %v, want %v", dup, existing)
+	}
+
+	if dup := findDuplicateParent(networks, "eth0.41"); dup != nil {
+		t.Errorf("findDuplicateParent() = %v, want nil", dup)
+	}
+
+	if dup := findDuplicateParent(nil, "eth0.40"); dup != nil {
+		t.Errorf("findDuplicateParent(nil, ...) = %v, want nil", dup)
+	}
+}
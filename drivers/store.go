@@ -0,0 +1,50 @@
+package drivers
+
+import "github.com/Sirupsen/logrus"
+
+// KVObject is implemented by driver records (endpoints, network
+// configuration) that round-trip through the configured MacStore.
+type KVObject interface {
+	Key() []string
+	Value() []byte
+	SetValue([]byte) error
+	Index() uint64
+	SetIndex(uint64)
+	Exists() bool
+	SetExists(bool)
+}
+
+// MacStore is the persistence backend for the macvlan/ipvlan driver. It is
+// supplied by the plugin's main package and lets network and endpoint state
+// survive a process restart.
+type MacStore interface {
+	InitStore(d *Driver) error
+	StoreUpdate(kvObject KVObject) error
+	StoreDelete(kvObject KVObject) error
+	// List returns every record stored under prefix (e.g. a driver type
+	// name or "endpoint"), using newObject to allocate a blank KVObject for
+	// each record before populating it via SetValue.
+	List(prefix string, newObject func() KVObject) ([]KVObject, error)
+}
+
+// storeUpdate persists kvObject, logging and continuing on if no store is
+// configured rather than failing the calling request.
+func (d *Driver) storeUpdate(kvObject KVObject) error {
+	if d.Store == nil {
+		logrus.Debugf("macvlan store not initialized, skipping update for %v", kvObject.Key())
+		return nil
+	}
+
+	return d.Store.StoreUpdate(kvObject)
+}
+
+// storeDelete removes kvObject from the store, logging and continuing on if
+// no store is configured.
+func (d *Driver) storeDelete(kvObject KVObject) error {
+	if d.Store == nil {
+		logrus.Debugf("macvlan store not initialized, skipping delete for %v", kvObject.Key())
+		return nil
+	}
+
+	return d.Store.StoreDelete(kvObject)
+}